@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace for all metrics emitted by this exporter.
+const namespace = "postgres"
+
+const (
+	defaultEnabled  = true
+	defaultDisabled = false
+)
+
+var (
+	factories      = make(map[string]func() (Collector, error))
+	collectorState = make(map[string]bool)
+)
+
+// Collector is implemented by each postgres_exporter collector. Update is
+// called once per scrape and should send every metric it owns to ch before
+// returning. db is the shared connection pool; collectors must not retain
+// connections across calls to Update.
+type Collector interface {
+	Update(ctx context.Context, db *pgxpool.Pool, ch chan<- prometheus.Metric) error
+}
+
+// registerCollector adds a collector factory to the registry under name,
+// recording whether it should run by default. main wires collectorState
+// entries up to CLI flags so operators can enable/disable collectors
+// individually.
+func registerCollector(name string, isDefaultEnabled bool, factory func() (Collector, error)) {
+	collectorState[name] = isDefaultEnabled
+	factories[name] = factory
+}
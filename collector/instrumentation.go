@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// queryTimeout bounds how long any single collector query is allowed to run
+// before the scrape gives up on it.
+const queryTimeout = 10 * time.Second
+
+var (
+	queryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "collector_query_duration_seconds",
+			Help:      "Duration of individual collector queries.",
+		},
+		[]string{"collector", "query"},
+	)
+	queryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "collector_query_errors_total",
+			Help:      "Total number of collector queries that returned an error, including timeouts.",
+		},
+		[]string{"collector", "query"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(queryDuration, queryErrors)
+}
+
+// runQuery executes fn under a bounded per-query timeout and records its
+// duration and outcome as postgres_exporter_collector_query_duration_seconds
+// and postgres_exporter_collector_query_errors_total, labelled by collector
+// and query.
+//
+// pgx's Query/QueryRow can block on the underlying network read even once
+// ctx is cancelled, so a context deadline alone would not stop a stuck query
+// from stalling the scrape. Instead fn runs in its own goroutine and we
+// select on ctx.Done(): on timeout we return ctx.Err() immediately and leave
+// fn's goroutine to finish (or be reaped when the pool drops the
+// connection), rather than block the caller on it.
+//
+// Because fn's goroutine can outlive runQuery, fn must never send to ch
+// directly with a bare `ch <- metric` — ch is only drained for as long as
+// the scrape that called runQuery is still running, and a goroutine that
+// outlives it would block on that send forever, leaking both the goroutine
+// and its pooled connection. fn should use sendMetric/forwardMetrics
+// instead, which give up cleanly once ctx is done.
+func runQuery(ctx context.Context, collector, query string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	queryDuration.WithLabelValues(collector, query).Observe(time.Since(start).Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(collector, query).Inc()
+	}
+	return err
+}
+
+// sendMetric sends m to ch, but gives up without blocking once ctx is done
+// instead of waiting for a reader that may never come back. Collectors
+// running inside runQuery must use this (or forwardMetrics) rather than
+// sending to ch directly; see runQuery's doc comment.
+func sendMetric(ctx context.Context, ch chan<- prometheus.Metric, m prometheus.Metric) {
+	select {
+	case ch <- m:
+	case <-ctx.Done():
+	}
+}
+
+// forwardMetrics drains c (typically a *prometheus.HistogramVec or similar
+// vector a collector builds up over a scrape) into ch via sendMetric, so the
+// same post-timeout safety applies as for a single metric.
+func forwardMetrics(ctx context.Context, ch chan<- prometheus.Metric, c prometheus.Collector) {
+	internal := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(internal)
+		close(internal)
+	}()
+	for m := range internal {
+		sendMetric(ctx, ch, m)
+	}
+}
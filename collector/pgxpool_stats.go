@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const pgxpoolStatsSubsystem = "pgxpool_stats"
+
+type pgxpoolStatsCollector struct {
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	acquiredConns        *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+	constructingConns    *prometheus.Desc
+	idleConns            *prometheus.Desc
+	maxConns             *prometheus.Desc
+}
+
+func init() {
+	registerCollector("pgxpool_stats", defaultEnabled, NewPgxpoolStatsCollector)
+}
+
+// NewPgxpoolStatsCollector returns a new Collector exposing exporter-side
+// connection pool health, rather than anything reported by Postgres itself.
+func NewPgxpoolStatsCollector() (Collector, error) {
+	return &pgxpoolStatsCollector{
+		acquireCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pgxpoolStatsSubsystem, "acquires_total"),
+			"Cumulative count of successful connection acquires from the pool",
+			nil,
+			nil,
+		),
+		acquireDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pgxpoolStatsSubsystem, "acquire_duration_seconds_total"),
+			"Cumulative time spent waiting for successful connection acquires from the pool",
+			nil,
+			nil,
+		),
+		acquiredConns: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pgxpoolStatsSubsystem, "acquired_connections"),
+			"Number of connections currently acquired by exporter collectors",
+			nil,
+			nil,
+		),
+		canceledAcquireCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pgxpoolStatsSubsystem, "canceled_acquires_total"),
+			"Cumulative count of acquires from the pool that were canceled by a context",
+			nil,
+			nil,
+		),
+		constructingConns: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pgxpoolStatsSubsystem, "constructing_connections"),
+			"Number of connections currently being established",
+			nil,
+			nil,
+		),
+		idleConns: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pgxpoolStatsSubsystem, "idle_connections"),
+			"Number of currently idle connections in the pool",
+			nil,
+			nil,
+		),
+		maxConns: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pgxpoolStatsSubsystem, "max_connections"),
+			"Maximum number of connections the pool will open",
+			nil,
+			nil,
+		),
+	}, nil
+}
+
+func (c *pgxpoolStatsCollector) Update(ctx context.Context, db *pgxpool.Pool, ch chan<- prometheus.Metric) error {
+	stat := db.Stat()
+
+	// postgres_pgxpool_stats_acquires_total
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	// postgres_pgxpool_stats_acquire_duration_seconds_total
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	// postgres_pgxpool_stats_acquired_connections
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	// postgres_pgxpool_stats_canceled_acquires_total
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	// postgres_pgxpool_stats_constructing_connections
+	ch <- prometheus.MustNewConstMetric(c.constructingConns, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	// postgres_pgxpool_stats_idle_connections
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	// postgres_pgxpool_stats_max_connections
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+// ProbeHandler implements the Prometheus multi-target exporter pattern: the
+// Postgres instance to scrape is given by the request's ?target= query
+// parameter instead of a single exporter-wide DSN. targets resolves each
+// target's credentials/TLS/enabled_collectors overrides; pools caches one
+// *pgxpool.Pool per resolved DSN and collectors caches each target's built
+// collector instances, so repeated probes of the same target reuse
+// connections and collectors rather than reconnecting and rebuilding them
+// every scrape — collectors can own long-lived resources (userqueries'
+// config-file watcher) that must not be recreated per request.
+func ProbeHandler(targets *TargetsFile, pools *PoolCache, collectors *TargetCollectorCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targetURL := r.URL.Query().Get("target")
+		if targetURL == "" {
+			http.Error(w, "target parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		cfg := targets.ForTarget(targetURL)
+
+		dsn := targetURL
+		if cfg != nil {
+			var err error
+			dsn, err = cfg.ApplyCredentials(targetURL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		pool, err := pools.Get(r.Context(), dsn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		built, err := collectors.Get(dsn, EnabledCollectorsForTarget(cfg))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(newTargetCollector(r.Context(), pool, built))
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// targetCollector adapts a target's already-built collector instances to a
+// prometheus.Collector scoped to a single /probe request against pool.
+type targetCollector struct {
+	ctx        context.Context
+	pool       *pgxpool.Pool
+	collectors map[string]Collector
+}
+
+func newTargetCollector(ctx context.Context, pool *pgxpool.Pool, collectors map[string]Collector) *targetCollector {
+	return &targetCollector{ctx: ctx, pool: pool, collectors: collectors}
+}
+
+// Describe intentionally sends nothing: per-target collectors such as
+// userqueries build their descriptor set from a config file, so it can't be
+// known statically. This makes targetCollector an "unchecked" collector,
+// which prometheus.Registry supports by calling Collect to discover
+// descriptors instead of requiring Describe to enumerate them up front.
+func (t *targetCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (t *targetCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, c := range t.collectors {
+		if err := c.Update(t.ctx, t.pool, ch); err != nil {
+			log.Errorf("probe: collector %s failed: %v", name, err)
+		}
+	}
+}
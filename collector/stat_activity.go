@@ -2,9 +2,10 @@ package collector
 
 import (
 	"context"
+	"strconv"
 	"time"
 
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -62,14 +63,58 @@ SELECT EXTRACT(EPOCH FROM age(clock_timestamp(), coalesce(min(query_start), cloc
   FROM pg_stat_activity
  WHERE backend_xmin IS NOT NULL
  GROUP BY application_name, datname /*postgres_exporter*/`
+
+	// Per-backend detail, one row per backend with a non-NULL state. Only collected when
+	// --collector.stat_activity.per-backend is set, since pid-labelled
+	// series are unbounded in cardinality.
+	statActivityCollectorBackendDetailQuery = `
+SELECT pid
+     , coalesce(usename, '')
+     , application_name
+     , coalesce(datname, '')
+     , coalesce(wait_event_type, '')
+     , coalesce(wait_event, '')
+     , coalesce(backend_type, '')
+     , state
+  FROM pg_stat_activity
+ WHERE state IS NOT NULL /*postgres_exporter*/`
+
+	// Wait events aggregated across all backends currently waiting on something.
+	statActivityCollectorWaitEventsQuery = `
+SELECT wait_event_type, wait_event, coalesce(datname, ''), count(*)::float
+  FROM pg_stat_activity
+ WHERE wait_event_type IS NOT NULL
+ GROUP BY wait_event_type, wait_event, datname /*postgres_exporter*/`
+
+	// Oldest transaction broken down by role and backend type, so app-held
+	// long transactions can be told apart from replication/vacuum. usename
+	// and backend_type are coalesced because background workers (autovacuum,
+	// logical replication) can hold a backend_xid with a NULL usename, and an
+	// unscoped NULL would fail the Scan below on every default-path scrape.
+	statActivityCollectorXactByRoleQuery = `
+SELECT EXTRACT(EPOCH FROM age(clock_timestamp(), coalesce(min(xact_start), current_timestamp))) AS xact_start
+     , coalesce(usename, '')
+     , coalesce(backend_type, '')
+  FROM pg_stat_activity
+ WHERE state IN ('idle in transaction', 'active')
+   AND backend_xid IS NOT NULL
+ GROUP BY usename, backend_type /*postgres_exporter*/`
 )
 
+// perBackendEnabled gates the high-cardinality, pid-labelled metrics below.
+// main wires this up to the --collector.stat_activity.per-backend flag; it
+// defaults to off.
+var perBackendEnabled bool
+
 type statActivityCollector struct {
-	connections *prometheus.Desc
-	backend     *prometheus.Desc
-	xact        *prometheus.Desc
-	active      *prometheus.Desc
-	snapshot    *prometheus.Desc
+	connections   *prometheus.Desc
+	backend       *prometheus.Desc
+	xact          *prometheus.Desc
+	active        *prometheus.Desc
+	snapshot      *prometheus.Desc
+	backendDetail *prometheus.Desc
+	waitEvents    *prometheus.Desc
+	xactByRole    *prometheus.Desc
 }
 
 func init() {
@@ -109,99 +154,216 @@ func NewStatActivityCollector() (Collector, error) {
 			[]string{"application_name", "datname"},
 			nil,
 		),
+		backendDetail: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statActivitySubsystem, "backend"),
+			"Per-backend detail from pg_stat_activity; only collected with --collector.stat_activity.per-backend",
+			[]string{"pid", "usename", "application_name", "datname", "wait_event_type", "wait_event", "backend_type", "state"},
+			nil,
+		),
+		waitEvents: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statActivitySubsystem, "wait_events"),
+			"Number of backends currently waiting on each wait event",
+			[]string{"wait_event_type", "wait_event", "datname"},
+			nil,
+		),
+		xactByRole: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, statActivitySubsystem, "oldest_xact_by_role_seconds"),
+			"The oldest transaction (active or idle in transaction), by role and backend type",
+			[]string{"usename", "backend_type"},
+			nil,
+		),
 	}, nil
 }
 
-func (c *statActivityCollector) Update(ctx context.Context, db *pgx.Conn, ch chan<- prometheus.Metric) error {
-	rows, err := db.QueryEx(ctx, statActivityQuery, nil)
+func (c *statActivityCollector) Update(ctx context.Context, db *pgxpool.Pool, ch chan<- prometheus.Metric) error {
+	err := runQuery(ctx, statActivitySubsystem, "connections", func(ctx context.Context) error {
+		rows, err := db.Query(ctx, statActivityQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var datname, state string
+		var count float64
+
+		for rows.Next() {
+			if err := rows.Scan(&datname, &state, &count); err != nil {
+				return err
+			}
+
+			// postgres_stat_activity_connections
+			sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, count, datname, state))
+		}
+
+		return rows.Err()
+	})
 	if err != nil {
 		return err
 	}
 
-	var applicationName, datname, state string
-	var count, oldestTx, oldestActive, oldestSnapshot float64
-	var oldestBackend time.Time
-
-	for rows.Next() {
-		if err := rows.Scan(&datname, &state, &count); err != nil {
+	err = runQuery(ctx, statActivitySubsystem, "oldest_backend", func(ctx context.Context) error {
+		var oldestBackend time.Time
+		if err := db.QueryRow(ctx, statActivityCollectorBackendStartQuery).Scan(&oldestBackend); err != nil {
 			return err
 		}
 
-		// postgres_stat_activity_connections
-		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, count, datname, state)
-	}
-
-	err = rows.Err()
+		// postgres_stat_activity_oldest_backend_timestamp
+		sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.backend, prometheus.GaugeValue, float64(oldestBackend.UTC().Unix())))
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	rows.Close()
 
-	err = db.QueryRowEx(ctx, statActivityCollectorBackendStartQuery, nil).Scan(&oldestBackend)
+	err = runQuery(ctx, statActivitySubsystem, "oldest_xact", func(ctx context.Context) error {
+		rows, err := db.Query(ctx, statActivityCollectorXactQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var applicationName, datname string
+		var oldestTx float64
+
+		for rows.Next() {
+			if err := rows.Scan(&oldestTx, &applicationName, &datname); err != nil {
+				return err
+			}
+
+			// postgres_stat_activity_oldest_xact_seconds
+			sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.xact, prometheus.GaugeValue, oldestTx, applicationName, datname))
+		}
+
+		return rows.Err()
+	})
 	if err != nil {
 		return err
 	}
 
-	// postgres_stat_activity_oldest_backend_timestamp
-	ch <- prometheus.MustNewConstMetric(c.backend, prometheus.GaugeValue, float64(oldestBackend.UTC().Unix()))
+	err = runQuery(ctx, statActivitySubsystem, "oldest_query_active", func(ctx context.Context) error {
+		rows, err := db.Query(ctx, statActivityCollectorActiveQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var applicationName, datname string
+		var oldestActive float64
+
+		for rows.Next() {
+			if err := rows.Scan(&oldestActive, &applicationName, &datname); err != nil {
+				return err
+			}
+
+			// postgres_stat_activity_oldest_query_active_seconds
+			sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, oldestActive, applicationName, datname))
+		}
 
-	if rows, err = db.QueryEx(ctx, statActivityCollectorXactQuery, nil); err != nil {
+		return rows.Err()
+	})
+	if err != nil {
 		return err
 	}
 
-	for rows.Next() {
-		if err := rows.Scan(&oldestTx, &applicationName, &datname); err != nil {
+	err = runQuery(ctx, statActivitySubsystem, "oldest_snapshot", func(ctx context.Context) error {
+		rows, err := db.Query(ctx, statActivityCollectorOldestSnapshotQuery)
+		if err != nil {
 			return err
 		}
+		defer rows.Close()
 
-		// postgres_stat_activity_oldest_xact_seconds
-		ch <- prometheus.MustNewConstMetric(c.xact, prometheus.GaugeValue, oldestTx, applicationName, datname)
-	}
+		var applicationName, datname string
+		var oldestSnapshot float64
+
+		for rows.Next() {
+			if err := rows.Scan(&oldestSnapshot, &applicationName, &datname); err != nil {
+				return err
+			}
+
+			// postgres_stat_activity_oldest_snapshot_seconds
+			sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.snapshot, prometheus.GaugeValue, oldestSnapshot, applicationName, datname))
+		}
 
-	err = rows.Err()
+		return rows.Err()
+	})
 	if err != nil {
 		return err
 	}
-	rows.Close()
 
-	if rows, err = db.QueryEx(ctx, statActivityCollectorActiveQuery, nil); err != nil {
+	err = runQuery(ctx, statActivitySubsystem, "oldest_xact_by_role", func(ctx context.Context) error {
+		rows, err := db.Query(ctx, statActivityCollectorXactByRoleQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var usename, backendType string
+		var oldestTx float64
+
+		for rows.Next() {
+			if err := rows.Scan(&oldestTx, &usename, &backendType); err != nil {
+				return err
+			}
+
+			// postgres_stat_activity_oldest_xact_by_role_seconds
+			sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.xactByRole, prometheus.GaugeValue, oldestTx, usename, backendType))
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
 		return err
 	}
 
-	for rows.Next() {
-		if err := rows.Scan(&oldestActive, &applicationName, &datname); err != nil {
+	err = runQuery(ctx, statActivitySubsystem, "wait_events", func(ctx context.Context) error {
+		rows, err := db.Query(ctx, statActivityCollectorWaitEventsQuery)
+		if err != nil {
 			return err
 		}
+		defer rows.Close()
 
-		// postgres_stat_activity_oldest_query_active_seconds
-		ch <- prometheus.MustNewConstMetric(c.active, prometheus.GaugeValue, oldestActive, applicationName, datname)
-	}
+		var waitEventType, waitEvent, datname string
+		var count float64
 
-	err = rows.Err()
+		for rows.Next() {
+			if err := rows.Scan(&waitEventType, &waitEvent, &datname, &count); err != nil {
+				return err
+			}
+
+			// postgres_stat_activity_wait_events
+			sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.waitEvents, prometheus.GaugeValue, count, waitEventType, waitEvent, datname))
+		}
+
+		return rows.Err()
+	})
 	if err != nil {
 		return err
 	}
-	rows.Close()
 
-	if rows, err = db.QueryEx(ctx, statActivityCollectorOldestSnapshotQuery, nil); err != nil {
-		return err
+	if !perBackendEnabled {
+		return nil
 	}
 
-	for rows.Next() {
-		if err := rows.Scan(&oldestSnapshot, &applicationName, &datname); err != nil {
+	return runQuery(ctx, statActivitySubsystem, "backend_detail", func(ctx context.Context) error {
+		rows, err := db.Query(ctx, statActivityCollectorBackendDetailQuery)
+		if err != nil {
 			return err
 		}
+		defer rows.Close()
 
-		// postgres_stat_activity_oldest_snapshot_seconds
-		ch <- prometheus.MustNewConstMetric(c.snapshot, prometheus.GaugeValue, oldestSnapshot, applicationName, datname)
+		var pid int32
+		var usename, applicationName, datname, waitEventType, waitEvent, backendType, state string
 
-	}
+		for rows.Next() {
+			if err := rows.Scan(&pid, &usename, &applicationName, &datname, &waitEventType, &waitEvent, &backendType, &state); err != nil {
+				return err
+			}
 
-	err = rows.Err()
-	if err != nil {
-		return err
-	}
-	rows.Close()
+			// postgres_stat_activity_backend
+			sendMetric(ctx, ch, prometheus.MustNewConstMetric(c.backendDetail, prometheus.GaugeValue, 1,
+				strconv.Itoa(int(pid)), usename, applicationName, datname, waitEventType, waitEvent, backendType, state))
+		}
 
-	return nil
+		return rows.Err()
+	})
 }
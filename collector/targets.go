@@ -0,0 +1,263 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// TargetConfig describes how to connect to, and which collectors to run
+// against, Postgres instances whose connection URL matches Pattern.
+//
+// Pattern is a glob over the whole target URL, not a filesystem path: '*'
+// matches any sequence of characters, including '/' and ':', and every other
+// character matches literally. For example "postgres://*@primary.db:5432/*"
+// matches any user/database on primary.db:5432.
+type TargetConfig struct {
+	Pattern           string   `yaml:"pattern"`
+	Username          string   `yaml:"username"`
+	Password          string   `yaml:"password"`
+	SSLMode           string   `yaml:"sslmode"`
+	EnabledCollectors []string `yaml:"enabled_collectors"`
+}
+
+// TargetsFile is the top-level shape of the multi-target config file, which
+// maps target URL patterns to per-target credentials, TLS settings and
+// enabled_collectors overrides for the /probe endpoint.
+type TargetsFile struct {
+	Targets []TargetConfig `yaml:"targets"`
+}
+
+// LoadTargetsFile reads and parses a multi-target config file.
+func LoadTargetsFile(path string) (*TargetsFile, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file TargetsFile
+	if err := yaml.UnmarshalStrict(raw, &file); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// ForTarget returns the first TargetConfig whose Pattern matches targetURL,
+// or nil if none match.
+func (f *TargetsFile) ForTarget(targetURL string) *TargetConfig {
+	for i := range f.Targets {
+		if matchTargetPattern(f.Targets[i].Pattern, targetURL) {
+			return &f.Targets[i]
+		}
+	}
+	return nil
+}
+
+// matchTargetPattern reports whether pattern, a '*'-wildcard glob over the
+// whole string, matches s. Unlike filepath.Match, '*' crosses '/' and ':' so
+// patterns can usefully describe connection URLs and DSNs.
+func matchTargetPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+
+	return re.MatchString(s)
+}
+
+// ApplyCredentials overlays cfg's username/password/sslmode onto targetURL
+// (the raw value of the /probe request's ?target= parameter), producing the
+// DSN actually used to connect.
+func (cfg *TargetConfig) ApplyCredentials(targetURL string) (string, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing target url: %w", err)
+	}
+
+	switch {
+	case cfg.Username != "" && cfg.Password != "":
+		u.User = url.UserPassword(cfg.Username, cfg.Password)
+	case cfg.Username != "":
+		u.User = url.User(cfg.Username)
+	case cfg.Password != "":
+		// Apply the password override even without a username override, so a
+		// config that only sets password (to inject a secret for a target
+		// whose username is already in the target URL) isn't silently
+		// dropped. Keep whatever username the target URL already carries.
+		u.User = url.UserPassword(u.User.Username(), cfg.Password)
+	}
+
+	if cfg.SSLMode != "" {
+		q := u.Query()
+		q.Set("sslmode", cfg.SSLMode)
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// EnabledCollectorsForTarget returns the names of collectors that should run
+// for cfg: cfg's enabled_collectors list when it sets one, which replaces the
+// defaults entirely, and otherwise every collector that is enabled by default.
+func EnabledCollectorsForTarget(cfg *TargetConfig) []string {
+	if cfg != nil && cfg.EnabledCollectors != nil {
+		return cfg.EnabledCollectors
+	}
+
+	enabled := make([]string, 0, len(collectorState))
+	for name, isDefaultEnabled := range collectorState {
+		if isDefaultEnabled {
+			enabled = append(enabled, name)
+		}
+	}
+	return enabled
+}
+
+// poolEntry lazily connects its pool exactly once, regardless of how many
+// goroutines call PoolCache.Get for the same DSN concurrently.
+type poolEntry struct {
+	once sync.Once
+	pool *pgxpool.Pool
+	err  error
+}
+
+// PoolCache holds one *pgxpool.Pool per target DSN, so repeated /probe
+// requests for the same target reuse connections instead of dialing fresh
+// ones on every scrape. Callers are expected to share a single PoolCache
+// across all /probe requests handled by the exporter process.
+type PoolCache struct {
+	mu    sync.Mutex
+	pools map[string]*poolEntry
+}
+
+// NewPoolCache returns an empty PoolCache.
+func NewPoolCache() *PoolCache {
+	return &PoolCache{pools: make(map[string]*poolEntry)}
+}
+
+// Get returns the cached pool for dsn, connecting and caching one if this is
+// the first request for it. Only concurrent Gets for the same dsn wait on
+// each other; a slow or unreachable target never blocks Get for any other
+// dsn, since the connect itself happens outside PoolCache's mutex.
+func (c *PoolCache) Get(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
+	c.mu.Lock()
+	entry, ok := c.pools[dsn]
+	if !ok {
+		entry = &poolEntry{}
+		c.pools[dsn] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.pool, entry.err = pgxpool.Connect(ctx, dsn)
+	})
+
+	if entry.err != nil {
+		// Don't let a failed connect permanently poison this dsn: drop the
+		// entry so the next Get retries instead of replaying the same error
+		// forever.
+		c.mu.Lock()
+		if c.pools[dsn] == entry {
+			delete(c.pools, dsn)
+		}
+		c.mu.Unlock()
+		return nil, fmt.Errorf("connecting to target: %w", entry.err)
+	}
+
+	return entry.pool, nil
+}
+
+// Close closes every cached pool. Intended for use at exporter shutdown.
+func (c *PoolCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for dsn, entry := range c.pools {
+		if entry.pool != nil {
+			entry.pool.Close()
+		}
+		delete(c.pools, dsn)
+	}
+}
+
+// collectorSetEntry lazily builds a target's enabled collector instances
+// exactly once, regardless of how many scrapes request them.
+type collectorSetEntry struct {
+	once       sync.Once
+	collectors map[string]Collector
+	err        error
+}
+
+// TargetCollectorCache holds each target's built collector instances, keyed
+// by DSN, so collectors are constructed once per target and reused across
+// scrapes rather than being rebuilt on every /probe request. That matters
+// beyond the cost of construction: some collectors own long-lived resources
+// (userqueries starts an fsnotify watcher goroutine in its constructor)
+// that must not be recreated on every scrape, or they leak.
+type TargetCollectorCache struct {
+	mu      sync.Mutex
+	targets map[string]*collectorSetEntry
+}
+
+// NewTargetCollectorCache returns an empty TargetCollectorCache.
+func NewTargetCollectorCache() *TargetCollectorCache {
+	return &TargetCollectorCache{targets: make(map[string]*collectorSetEntry)}
+}
+
+// Get returns the collector instances enabled for dsn, building them from
+// factories the first time dsn is probed. enabled is only consulted on that
+// first build; it's expected to be stable for a given dsn, since it comes
+// from the same target config that determined dsn in the first place.
+func (c *TargetCollectorCache) Get(dsn string, enabled []string) (map[string]Collector, error) {
+	c.mu.Lock()
+	entry, ok := c.targets[dsn]
+	if !ok {
+		entry = &collectorSetEntry{}
+		c.targets[dsn] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		built := make(map[string]Collector, len(enabled))
+		for _, name := range enabled {
+			newCollector, ok := factories[name]
+			if !ok {
+				entry.err = fmt.Errorf("unknown collector %q", name)
+				return
+			}
+
+			collector, err := newCollector()
+			if err != nil {
+				entry.err = fmt.Errorf("building collector %s: %w", name, err)
+				return
+			}
+
+			built[name] = collector
+		}
+		entry.collectors = built
+	})
+
+	if entry.err != nil {
+		c.mu.Lock()
+		if c.targets[dsn] == entry {
+			delete(c.targets, dsn)
+		}
+		c.mu.Unlock()
+		return nil, entry.err
+	}
+
+	return entry.collectors, nil
+}
@@ -0,0 +1,343 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const userQueriesSubsystem = "userqueries"
+
+// userQueriesConfigFile is the path to the YAML file describing user-defined
+// queries. main wires this up to the --collector.userqueries.file flag; an
+// empty path leaves the collector registered but inert.
+var userQueriesConfigFile string
+
+var (
+	userQueriesReloadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "userqueries_reload_total",
+			Help:      "Total number of attempts to reload the userqueries config file, by result.",
+		},
+		[]string{"result"},
+	)
+	userQueriesLastReloadTimestampSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "userqueries_last_reload_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful userqueries config reload.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(userQueriesReloadsTotal, userQueriesLastReloadTimestampSeconds)
+	registerCollector("userqueries", defaultDisabled, NewUserQueriesCollector)
+}
+
+// userQueryConfig describes one user-defined query as read from the
+// userqueries YAML config file.
+type userQueryConfig struct {
+	Name         string   `yaml:"name"`
+	Help         string   `yaml:"help"`
+	Type         string   `yaml:"type"` // gauge, counter or histogram
+	Query        string   `yaml:"query"`
+	ValueColumn  string   `yaml:"value_column"`
+	LabelColumns []string `yaml:"label_columns"`
+}
+
+type userQueriesFile struct {
+	Queries []userQueryConfig `yaml:"queries"`
+}
+
+// userQuery is a userQueryConfig compiled into something Update can execute
+// and emit directly.
+type userQuery struct {
+	cfg       userQueryConfig
+	valueType prometheus.ValueType
+	desc      *prometheus.Desc        // gauge, counter
+	hist      *prometheus.HistogramVec // histogram
+}
+
+type userQueriesCollector struct {
+	mu      sync.RWMutex
+	queries []userQuery
+}
+
+// NewUserQueriesCollector returns a new Collector that runs operator-defined
+// SQL queries described by the file at userQueriesConfigFile. The file is
+// watched with fsnotify and reloaded on change, without restarting the
+// exporter.
+func NewUserQueriesCollector() (Collector, error) {
+	c := &userQueriesCollector{}
+
+	if userQueriesConfigFile == "" {
+		return c, nil
+	}
+
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	if err := c.watch(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// watch starts a background goroutine that reloads the config whenever
+// userQueriesConfigFile changes. The containing directory is watched (rather
+// than the file itself) because editors and config managers commonly replace
+// the file instead of writing it in place, which looks like a remove/create
+// pair rather than a write to the original inode.
+func (c *userQueriesCollector) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(userQueriesConfigFile)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	name := filepath.Base(userQueriesConfigFile)
+
+	go func() {
+		defer watcher.Close()
+		for event := range watcher.Events {
+			if filepath.Base(event.Name) != name {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := c.reload(); err != nil {
+				log.Errorf("userqueries: failed to reload %s: %v", userQueriesConfigFile, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reload re-reads userQueriesConfigFile and swaps in the new descriptor set,
+// recording the outcome in the userqueries_reload metrics.
+func (c *userQueriesCollector) reload() error {
+	queries, err := c.load()
+	if err != nil {
+		userQueriesReloadsTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+
+	c.mu.Lock()
+	c.queries = queries
+	c.mu.Unlock()
+
+	userQueriesReloadsTotal.WithLabelValues("success").Inc()
+	userQueriesLastReloadTimestampSeconds.SetToCurrentTime()
+	return nil
+}
+
+func (c *userQueriesCollector) load() ([]userQuery, error) {
+	raw, err := ioutil.ReadFile(userQueriesConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var file userQueriesFile
+	if err := yaml.UnmarshalStrict(raw, &file); err != nil {
+		return nil, err
+	}
+
+	queries := make([]userQuery, 0, len(file.Queries))
+	for _, cfg := range file.Queries {
+		q, err := compileUserQuery(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("userqueries: %s: %w", cfg.Name, err)
+		}
+		queries = append(queries, q)
+	}
+
+	return queries, nil
+}
+
+func compileUserQuery(cfg userQueryConfig) (userQuery, error) {
+	switch cfg.Type {
+	case "gauge":
+		return userQuery{
+			cfg:       cfg,
+			valueType: prometheus.GaugeValue,
+			desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, userQueriesSubsystem, cfg.Name), cfg.Help, cfg.LabelColumns, nil),
+		}, nil
+	case "counter":
+		return userQuery{
+			cfg:       cfg,
+			valueType: prometheus.CounterValue,
+			desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, userQueriesSubsystem, cfg.Name), cfg.Help, cfg.LabelColumns, nil),
+		}, nil
+	case "histogram":
+		return userQuery{
+			cfg: cfg,
+			hist: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: userQueriesSubsystem,
+				Name:      cfg.Name,
+				Help:      cfg.Help,
+			}, cfg.LabelColumns),
+		}, nil
+	default:
+		return userQuery{}, fmt.Errorf("unknown metric type %q", cfg.Type)
+	}
+}
+
+func (c *userQueriesCollector) Update(ctx context.Context, db *pgxpool.Pool, ch chan<- prometheus.Metric) error {
+	c.mu.RLock()
+	queries := c.queries
+	c.mu.RUnlock()
+
+	for _, q := range queries {
+		q := q
+		err := runQuery(ctx, userQueriesSubsystem, q.cfg.Name, func(ctx context.Context) error {
+			return q.run(ctx, db, ch)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (q userQuery) run(ctx context.Context, db *pgxpool.Pool, ch chan<- prometheus.Metric) error {
+	rows, err := db.Query(ctx, q.cfg.Query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := columnIndex(rows.FieldDescriptions(), q.cfg)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return err
+		}
+
+		value, labels, err := q.scan(columns, values)
+		if err != nil {
+			return err
+		}
+
+		if q.hist != nil {
+			q.hist.WithLabelValues(labels...).Observe(value)
+			continue
+		}
+
+		sendMetric(ctx, ch, prometheus.MustNewConstMetric(q.desc, q.valueType, value, labels...))
+	}
+
+	if q.hist != nil {
+		forwardMetrics(ctx, ch, q.hist)
+	}
+
+	return rows.Err()
+}
+
+// queryColumns maps a userQuery's configured value_column/label_columns
+// names to their positions in a query's result set, so rows can be scanned
+// by name instead of assuming the query selects columns in config order.
+type queryColumns struct {
+	value  int
+	labels []int
+}
+
+// columnIndex resolves cfg's value_column and label_columns against fields,
+// the result set's actual column names, failing if any configured name is
+// missing.
+func columnIndex(fields []pgproto3.FieldDescription, cfg userQueryConfig) (queryColumns, error) {
+	byName := make(map[string]int, len(fields))
+	for i, f := range fields {
+		byName[string(f.Name)] = i
+	}
+
+	valueIdx, ok := byName[cfg.ValueColumn]
+	if !ok {
+		return queryColumns{}, fmt.Errorf("value_column %q not found in query result", cfg.ValueColumn)
+	}
+
+	labelIdxs := make([]int, len(cfg.LabelColumns))
+	for i, col := range cfg.LabelColumns {
+		idx, ok := byName[col]
+		if !ok {
+			return queryColumns{}, fmt.Errorf("label_columns: %q not found in query result", col)
+		}
+		labelIdxs[i] = idx
+	}
+
+	return queryColumns{value: valueIdx, labels: labelIdxs}, nil
+}
+
+// scan splits a query's result row into its metric value and label values,
+// using columns to locate value_column/label_columns by name regardless of
+// the order the query happens to select them in.
+func (q userQuery) scan(columns queryColumns, values []interface{}) (float64, []string, error) {
+	value, err := toFloat64(values[columns.value])
+	if err != nil {
+		return 0, nil, fmt.Errorf("column %s: %w", q.cfg.ValueColumn, err)
+	}
+
+	labels := make([]string, len(columns.labels))
+	for i, idx := range columns.labels {
+		labels[i] = fmt.Sprintf("%v", values[idx])
+	}
+
+	return value, labels, nil
+}
+
+// toFloat64 converts a scanned column value to the float64 a Prometheus
+// metric needs. numeric/decimal columns (pg_wal_lsn_diff-based lag queries,
+// bloat estimates, and other common userqueries use cases all return
+// numeric) decode via rows.Values() to pgtype.Numeric rather than a Go
+// numeric type, so that's handled explicitly via its AssignTo conversion.
+func toFloat64(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case pgtype.Numeric:
+		var f float64
+		if err := n.AssignTo(&f); err != nil {
+			return 0, fmt.Errorf("converting numeric value: %w", err)
+		}
+		return f, nil
+	case nil:
+		return 0, fmt.Errorf("value is NULL")
+	default:
+		return 0, fmt.Errorf("unsupported value type %T", v)
+	}
+}
@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+)
+
+func numeric(t *testing.T, s string) pgtype.Numeric {
+	t.Helper()
+	var n pgtype.Numeric
+	if err := n.Set(s); err != nil {
+		t.Fatalf("building pgtype.Numeric from %q: %v", s, err)
+	}
+	return n
+}
+
+func TestToFloat64(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      interface{}
+		want    float64
+		wantErr bool
+	}{
+		{name: "float64", in: float64(1.5), want: 1.5},
+		{name: "float32", in: float32(2.5), want: 2.5},
+		{name: "int64", in: int64(3), want: 3},
+		{name: "int32", in: int32(4), want: 4},
+		{name: "int", in: int(5), want: 5},
+		{name: "numeric, as decoded from a numeric/decimal column", in: numeric(t, "128.5"), want: 128.5},
+		{name: "NULL", in: nil, wantErr: true},
+		{name: "unsupported", in: "nope", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := toFloat64(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got value %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileUserQuery(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     userQueryConfig
+		wantErr bool
+	}{
+		{name: "gauge", cfg: userQueryConfig{Name: "g", Type: "gauge"}},
+		{name: "counter", cfg: userQueryConfig{Name: "c", Type: "counter"}},
+		{name: "histogram", cfg: userQueryConfig{Name: "h", Type: "histogram"}},
+		{name: "unknown", cfg: userQueryConfig{Name: "x", Type: "summary"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := compileUserQuery(tc.cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.cfg.Type == "histogram" && q.hist == nil {
+				t.Fatal("expected a HistogramVec to be built")
+			}
+			if tc.cfg.Type != "histogram" && q.desc == nil {
+				t.Fatal("expected a Desc to be built")
+			}
+		})
+	}
+}
+
+func fieldDescriptions(names ...string) []pgproto3.FieldDescription {
+	fields := make([]pgproto3.FieldDescription, len(names))
+	for i, name := range names {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(name)}
+	}
+	return fields
+}
+
+func TestColumnIndex(t *testing.T) {
+	cfg := userQueryConfig{
+		ValueColumn:  "lag_bytes",
+		LabelColumns: []string{"slot_name", "datname"},
+	}
+
+	// The query selects columns in a different order than they're declared
+	// in the config; columnIndex must still find each one by name.
+	fields := fieldDescriptions("datname", "lag_bytes", "slot_name")
+
+	columns, err := columnIndex(fields, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if columns.value != 1 {
+		t.Fatalf("value column index = %d, want 1", columns.value)
+	}
+	if want := []int{2, 0}; columns.labels[0] != want[0] || columns.labels[1] != want[1] {
+		t.Fatalf("label column indexes = %v, want %v", columns.labels, want)
+	}
+}
+
+func TestColumnIndexMissingColumn(t *testing.T) {
+	cfg := userQueryConfig{ValueColumn: "missing"}
+
+	if _, err := columnIndex(fieldDescriptions("other"), cfg); err == nil {
+		t.Fatal("expected an error for a missing value_column")
+	}
+
+	cfg = userQueryConfig{ValueColumn: "v", LabelColumns: []string{"missing"}}
+	if _, err := columnIndex(fieldDescriptions("v"), cfg); err == nil {
+		t.Fatal("expected an error for a missing label_column")
+	}
+}
+
+func TestUserQueryScan(t *testing.T) {
+	cfg := userQueryConfig{
+		ValueColumn:  "lag_bytes",
+		LabelColumns: []string{"slot_name", "datname"},
+	}
+	q := userQuery{cfg: cfg}
+
+	fields := fieldDescriptions("datname", "lag_bytes", "slot_name")
+	columns, err := columnIndex(fields, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Row values are positioned to match the fields above, not the config's
+	// value_column/label_columns order.
+	values := []interface{}{"mydb", float64(128), "myslot"}
+
+	value, labels, err := q.scan(columns, values)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 128 {
+		t.Fatalf("value = %v, want 128", value)
+	}
+	if labels[0] != "myslot" || labels[1] != "mydb" {
+		t.Fatalf("labels = %v, want [myslot mydb]", labels)
+	}
+}